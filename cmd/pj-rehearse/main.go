@@ -0,0 +1,147 @@
+// Command pj-rehearse determines which Prow presubmits should be
+// rehearsed against a pull request's proposed ci-operator config and
+// template changes, and records the outcome through the metrics sinks
+// selected on the command line.
+package main
+
+import (
+	"flag"
+	"log"
+	"reflect"
+
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+	"github.com/openshift/ci-operator-prowgen/pkg/rehearse"
+)
+
+type options struct {
+	metrics rehearse.MetricsOptions
+
+	oldCiopConfigDir   string
+	newCiopConfigDir   string
+	oldTemplateDir     string
+	newTemplateDir     string
+	jobConfigPath      string
+	promotionStepsPath string
+	maxRehearsals      int
+}
+
+func gatherOptions() options {
+	o := options{}
+	o.metrics.Bind(flag.CommandLine)
+	flag.StringVar(&o.oldCiopConfigDir, "old-ciop-configs-dir", "", "Directory of ci-operator configs before the change.")
+	flag.StringVar(&o.newCiopConfigDir, "new-ciop-configs-dir", "", "Directory of ci-operator configs after the change.")
+	flag.StringVar(&o.oldTemplateDir, "old-templates-dir", "", "Directory of ci-operator templates before the change.")
+	flag.StringVar(&o.newTemplateDir, "new-templates-dir", "", "Directory of ci-operator templates after the change.")
+	flag.StringVar(&o.jobConfigPath, "job-config-path", "", "Path to the Prow job config, keyed by the ci-operator config each presubmit tests.")
+	flag.StringVar(&o.promotionStepsPath, "promotion-steps-path", "", "Path to the aggregated output image tag step configuration for every known ci-operator config, used to resolve alias tags when scoring rehearsal opportunities.")
+	flag.IntVar(&o.maxRehearsals, "max-rehearsals", 5, "Maximum number of presubmits to actually rehearse in one run.")
+	flag.Parse()
+	return o
+}
+
+func main() {
+	o := gatherOptions()
+
+	metrics, err := o.metrics.Metrics()
+	if err != nil {
+		log.Fatalf("could not configure rehearsal metrics: %v", err)
+	}
+
+	oldCiopConfigs, err := config.LoadCompoundCiopConfig(o.oldCiopConfigDir)
+	if err != nil {
+		log.Fatalf("could not load ci-operator configs before the change: %v", err)
+	}
+	newCiopConfigs, err := config.LoadCompoundCiopConfig(o.newCiopConfigDir)
+	if err != nil {
+		log.Fatalf("could not load ci-operator configs after the change: %v", err)
+	}
+	changedCiopConfigs := diffCompoundCiopConfig(oldCiopConfigs, newCiopConfigs)
+	metrics.RecordChangedCiopConfigs(changedCiopConfigs)
+
+	oldTemplates, err := config.LoadCiTemplates(o.oldTemplateDir)
+	if err != nil {
+		log.Fatalf("could not load templates before the change: %v", err)
+	}
+	newTemplates, err := config.LoadCiTemplates(o.newTemplateDir)
+	if err != nil {
+		log.Fatalf("could not load templates after the change: %v", err)
+	}
+	changedTemplates := diffCiTemplates(oldTemplates, newTemplates)
+	metrics.RecordChangedTemplates(changedTemplates)
+
+	presubmitsForConfig, err := config.LoadPresubmitsByConfig(o.jobConfigPath)
+	if err != nil {
+		log.Fatalf("could not load presubmits: %v", err)
+	}
+	changedPresubmits := presubmitsForChangedConfigs(presubmitsForConfig, changedCiopConfigs)
+	metrics.RecordChangedPresubmits(changedPresubmits)
+
+	promotionSteps, err := config.LoadPromotionSteps(o.promotionStepsPath)
+	if err != nil {
+		log.Fatalf("could not load promotion steps: %v", err)
+	}
+	metrics.RecordGraphOpportunities(changedCiopConfigs, newCiopConfigs, changedTemplates, promotionSteps, presubmitsForConfig)
+
+	actual := selectRehearsals(changedPresubmits, o.maxRehearsals)
+	metrics.RecordActual(actual)
+
+	if err := metrics.Flush(); err != nil {
+		log.Fatalf("could not flush rehearsal metrics: %v", err)
+	}
+}
+
+// diffCompoundCiopConfig returns the entries of newConfigs that are new or
+// differ from what oldConfigs had under the same name.
+func diffCompoundCiopConfig(oldConfigs, newConfigs config.CompoundCiopConfig) config.CompoundCiopConfig {
+	changed := config.CompoundCiopConfig{}
+	for name, ciopConfig := range newConfigs {
+		if old, ok := oldConfigs[name]; !ok || !reflect.DeepEqual(old, ciopConfig) {
+			changed[name] = ciopConfig
+		}
+	}
+	return changed
+}
+
+// diffCiTemplates returns the entries of newTemplates that are new or
+// differ from what oldTemplates had under the same name.
+func diffCiTemplates(oldTemplates, newTemplates config.CiTemplates) config.CiTemplates {
+	changed := config.CiTemplates{}
+	for name, template := range newTemplates {
+		if old, ok := oldTemplates[name]; !ok || !reflect.DeepEqual(old, template) {
+			changed[name] = template
+		}
+	}
+	return changed
+}
+
+// presubmitsForChangedConfigs merges the presubmits registered against
+// every changed ci-operator config into a single Presubmits, so it can be
+// recorded and selected from as one set.
+func presubmitsForChangedConfigs(presubmitsForConfig map[string]config.Presubmits, changed config.CompoundCiopConfig) config.Presubmits {
+	merged := config.Presubmits{}
+	for name := range changed {
+		for repo, jobs := range presubmitsForConfig[name] {
+			merged[repo] = append(merged[repo], jobs...)
+		}
+	}
+	return merged
+}
+
+// selectRehearsals picks up to max presubmits out of changed to actually
+// rehearse. Rehearsing every changed presubmit on every PR would overload
+// the cluster on a PR that touches many configs at once, so the run caps
+// itself instead of triggering all of them.
+func selectRehearsals(changed config.Presubmits, max int) []*prowconfig.Presubmit {
+	var selected []*prowconfig.Presubmit
+	for _, jobs := range changed {
+		for i := range jobs {
+			if len(selected) >= max {
+				return selected
+			}
+			selected = append(selected, &jobs[i])
+		}
+	}
+	return selected
+}
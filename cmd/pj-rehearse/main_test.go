@@ -0,0 +1,70 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/ci-operator/pkg/api"
+	"k8s.io/apimachinery/pkg/util/diff"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+func TestDiffCompoundCiopConfig(t *testing.T) {
+	unchanged := &api.ReleaseBuildConfiguration{}
+	old := config.CompoundCiopConfig{
+		"unchanged-org-repo-branch.yaml": unchanged,
+		"removed-org-repo-branch.yaml":   &api.ReleaseBuildConfiguration{},
+		"modified-org-repo-branch.yaml":  &api.ReleaseBuildConfiguration{},
+	}
+	updated := config.CompoundCiopConfig{
+		"unchanged-org-repo-branch.yaml": unchanged,
+		"modified-org-repo-branch.yaml":  &api.ReleaseBuildConfiguration{BaseImages: map[string]api.ImageStreamTagReference{"base": {}}},
+		"added-org-repo-branch.yaml":     &api.ReleaseBuildConfiguration{},
+	}
+
+	changed := diffCompoundCiopConfig(old, updated)
+
+	expected := config.CompoundCiopConfig{
+		"modified-org-repo-branch.yaml": updated["modified-org-repo-branch.yaml"],
+		"added-org-repo-branch.yaml":    updated["added-org-repo-branch.yaml"],
+	}
+	if !reflect.DeepEqual(expected, changed) {
+		t.Errorf("changed configs differ from expected:\n%s", diff.ObjectReflectDiff(expected, changed))
+	}
+}
+
+func TestPresubmitsForChangedConfigs(t *testing.T) {
+	presubmitsForConfig := map[string]config.Presubmits{
+		"changed-org-repo-branch.yaml": {
+			"org/repo": {{JobBase: prowconfig.JobBase{Name: "org-repo-job"}}},
+		},
+		"unchanged-org-repo-branch.yaml": {
+			"org/other": {{JobBase: prowconfig.JobBase{Name: "org-other-job"}}},
+		},
+	}
+	changed := config.CompoundCiopConfig{"changed-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{}}
+
+	merged := presubmitsForChangedConfigs(presubmitsForConfig, changed)
+
+	expected := config.Presubmits{"org/repo": {{JobBase: prowconfig.JobBase{Name: "org-repo-job"}}}}
+	if !reflect.DeepEqual(expected, merged) {
+		t.Errorf("merged presubmits differ from expected:\n%s", diff.ObjectReflectDiff(expected, merged))
+	}
+}
+
+func TestSelectRehearsals(t *testing.T) {
+	changed := config.Presubmits{
+		"org/repo": {
+			{JobBase: prowconfig.JobBase{Name: "org-repo-job"}},
+			{JobBase: prowconfig.JobBase{Name: "org-repo-another-job"}},
+		},
+	}
+
+	selected := selectRehearsals(changed, 1)
+
+	if len(selected) != 1 {
+		t.Fatalf("expected selection to be capped at 1, got %d", len(selected))
+	}
+}
@@ -0,0 +1,185 @@
+package rehearse
+
+import (
+	"fmt"
+
+	"github.com/openshift/ci-operator/pkg/api"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+// tagRef identifies a single ImageStreamTag, the unit that base_images and
+// tag_specification entries in a ci-operator config resolve to.
+type tagRef struct {
+	Namespace string
+	Name      string
+	Tag       string
+}
+
+func (t tagRef) String() string {
+	return fmt.Sprintf("%s/%s:%s", t.Namespace, t.Name, t.Tag)
+}
+
+func newTagRef(ref api.ImageStreamTagReference) tagRef {
+	return tagRef{Namespace: ref.Namespace, Name: ref.Name, Tag: ref.Tag}
+}
+
+// taggedRef pairs a tagRef with the kind of config entry it came from
+// ("base_image" or "tag_specification"), so a match against it can report
+// which part of the config connected it to a change instead of guessing.
+type taggedRef struct {
+	tagRef
+	kind string
+}
+
+// ciopConfigTagRefs collects every ImageStreamTag a ci-operator config
+// depends on, as the set of base_images entries plus, if present, the
+// tag_specification stream itself.
+func ciopConfigTagRefs(ciopConfig *api.ReleaseBuildConfiguration) []taggedRef {
+	var refs []taggedRef
+	for _, baseImage := range ciopConfig.BaseImages {
+		refs = append(refs, taggedRef{tagRef: newTagRef(baseImage), kind: "base_image"})
+	}
+	if ciopConfig.ReleaseTagConfiguration != nil {
+		refs = append(refs, taggedRef{
+			tagRef: tagRef{
+				Namespace: ciopConfig.ReleaseTagConfiguration.Namespace,
+				Name:      ciopConfig.ReleaseTagConfiguration.Name,
+			},
+			kind: "tag_specification",
+		})
+	}
+	return refs
+}
+
+// configTemplates returns the names of the templates that a ci-operator
+// config's test steps reference, e.g. "awesome-openshift-installer" for a
+// test step configured with `template: awesome-openshift-installer`.
+func configTemplates(ciopConfig *api.ReleaseBuildConfiguration) []string {
+	var names []string
+	for _, test := range ciopConfig.Tests {
+		if len(test.Template) > 0 {
+			names = append(names, test.Template)
+		}
+	}
+	return names
+}
+
+// aliasGraph maps a tag to the tag it is an alias for. It is built from
+// the output image tag step configurations that run in Alias mode (see
+// buildAliasGraph), not read from the cluster, so that a change to the
+// tag an alias points at is recognized as also affecting everything built
+// against the alias.
+type aliasGraph map[tagRef]tagRef
+
+// buildAliasGraph constructs the alias dependency graph from the set of
+// output image tag step configurations across every known ci-operator
+// config. A step with Alias set causes its To tag to track the pipeline
+// tag it was built from, rather than pinning to a resolved digest, so a
+// change to that pipeline tag's published location must be treated as
+// also affecting anything built against the alias.
+func buildAliasGraph(steps []api.OutputImageTagStepConfiguration) aliasGraph {
+	g := aliasGraph{}
+	for _, step := range steps {
+		if !step.Alias {
+			continue
+		}
+		to := tagRef{Namespace: step.To.Namespace, Name: step.To.Name, Tag: step.To.Tag}
+		from := tagRef{Namespace: step.To.Namespace, Name: api.PipelineImageStream, Tag: string(step.From)}
+		g[to] = from
+	}
+	return g
+}
+
+// resolve follows the alias chain starting at ref until it reaches a tag
+// that isn't itself an alias, returning the final tag and the path of
+// tag names (starting with ref and ending with the resolved tag) that
+// were walked to get there. The walk stops before revisiting a tag rather
+// than looping forever: a tag that aliases to itself resolves to itself,
+// and a longer cycle resolves to the last tag reached before the repeat.
+func (g aliasGraph) resolve(ref tagRef) (tagRef, []string) {
+	path := []string{ref.String()}
+	visited := map[tagRef]bool{ref: true}
+	current := ref
+	for {
+		next, ok := g[current]
+		if !ok || visited[next] {
+			return current, path
+		}
+		visited[next] = true
+		current = next
+		path = append(path, current.String())
+	}
+}
+
+// OpportunityReason records why a presubmit was considered a rehearsal
+// candidate: the kind of change that triggered it (e.g. "template",
+// "base_image", "tag_specification"), the changed resource that started
+// the chain, and the path through the dependency graph that connects it
+// to the ci-operator config the presubmit tests, e.g. [template X, config Z]
+// or [alias tag A, base image tag Y, config Z].
+type OpportunityReason struct {
+	Kind   string   `json:"kind"`
+	Source string   `json:"source"`
+	Path   []string `json:"path"`
+}
+
+// graphOpportunities walks the base_images/tag_specification references
+// and the template usage of every ci-operator config, resolving tags
+// through aliasGraph, and returns an OpportunityReason for each config
+// that is connected - directly, through an alias, or through a shared
+// template - to one of the changed configs or changed templates. This
+// lets a reason like "job Z rehearses because it builds against base
+// image Y, which is a pure alias of changed base image W" be reported
+// instead of a flat "something changed".
+func graphOpportunities(changed, all config.CompoundCiopConfig, changedTemplates config.CiTemplates, aliases aliasGraph) map[string]OpportunityReason {
+	type changedTag struct {
+		kind   string
+		source string
+	}
+	changedTags := map[tagRef]changedTag{}
+	for name, ciopConfig := range changed {
+		for _, ref := range ciopConfigTagRefs(ciopConfig) {
+			resolved, _ := aliases.resolve(ref.tagRef)
+			changedTags[resolved] = changedTag{kind: ref.kind, source: name}
+		}
+	}
+
+	reasons := map[string]OpportunityReason{}
+	for name, ciopConfig := range all {
+		if _, ok := changed[name]; ok {
+			continue
+		}
+		var matched bool
+		for _, template := range configTemplates(ciopConfig) {
+			if _, ok := changedTemplates[template+".yaml"]; !ok {
+				continue
+			}
+			reasons[name] = OpportunityReason{
+				Kind:   "template",
+				Source: template,
+				Path:   []string{template, name},
+			}
+			matched = true
+			break
+		}
+		if matched {
+			continue
+		}
+		for _, ref := range ciopConfigTagRefs(ciopConfig) {
+			resolved, path := aliases.resolve(ref.tagRef)
+			tagged, ok := changedTags[resolved]
+			if !ok {
+				continue
+			}
+			path = append(path, name)
+			reasons[name] = OpportunityReason{
+				Kind:   tagged.kind,
+				Source: tagged.source,
+				Path:   path,
+			}
+			break
+		}
+	}
+	return reasons
+}
@@ -0,0 +1,193 @@
+package rehearse
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/openshift/ci-operator/pkg/api"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+func TestAliasGraphResolve(t *testing.T) {
+	base := tagRef{Namespace: "ci", Name: "base", Tag: "latest"}
+	alias := tagRef{Namespace: "ci", Name: "alias", Tag: "latest"}
+	transitiveAlias := tagRef{Namespace: "ci", Name: "transitive-alias", Tag: "latest"}
+
+	testCases := []struct {
+		description  string
+		graph        aliasGraph
+		start        tagRef
+		expectedTag  tagRef
+		expectedPath []string
+	}{{
+		description:  "not an alias",
+		graph:        aliasGraph{},
+		start:        base,
+		expectedTag:  base,
+		expectedPath: []string{base.String()},
+	}, {
+		description:  "single alias hop",
+		graph:        aliasGraph{alias: base},
+		start:        alias,
+		expectedTag:  base,
+		expectedPath: []string{alias.String(), base.String()},
+	}, {
+		description:  "transitive alias chain",
+		graph:        aliasGraph{transitiveAlias: alias, alias: base},
+		start:        transitiveAlias,
+		expectedTag:  base,
+		expectedPath: []string{transitiveAlias.String(), alias.String(), base.String()},
+	}, {
+		description:  "cycle stops instead of looping forever",
+		graph:        aliasGraph{alias: base, base: alias},
+		start:        alias,
+		expectedTag:  base,
+		expectedPath: []string{alias.String(), base.String()},
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			resolved, path := tc.graph.resolve(tc.start)
+			if resolved != tc.expectedTag {
+				t.Errorf("resolved tag %v differs from expected %v", resolved, tc.expectedTag)
+			}
+			if !reflect.DeepEqual(tc.expectedPath, path) {
+				t.Errorf("resolved path differs from expected:\n%s", diff.ObjectReflectDiff(tc.expectedPath, path))
+			}
+		})
+	}
+}
+
+func TestGraphOpportunities(t *testing.T) {
+	changed := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"base": {Namespace: "ci", Name: "base", Tag: "latest"},
+			},
+		},
+	}
+	all := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": changed["base-org-repo-branch.yaml"],
+		"consumer-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"alias": {Namespace: "ci", Name: "alias", Tag: "latest"},
+			},
+		},
+		"unrelated-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"other": {Namespace: "ci", Name: "other", Tag: "latest"},
+			},
+		},
+	}
+	aliases := aliasGraph{
+		{Namespace: "ci", Name: "alias", Tag: "latest"}: {Namespace: "ci", Name: "base", Tag: "latest"},
+	}
+
+	opportunities := graphOpportunities(changed, all, config.CiTemplates{}, aliases)
+
+	if _, ok := opportunities["unrelated-org-repo-branch.yaml"]; ok {
+		t.Errorf("expected no opportunity for a config with no relation to the change")
+	}
+	got, ok := opportunities["consumer-org-repo-branch.yaml"]
+	if !ok {
+		t.Fatalf("expected an opportunity for the config aliasing the changed base image")
+	}
+	expected := OpportunityReason{
+		Kind:   "base_image",
+		Source: "base-org-repo-branch.yaml",
+		Path: []string{
+			"ci/alias:latest",
+			"ci/base:latest",
+			"consumer-org-repo-branch.yaml",
+		},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("opportunity differs from expected:\n%s", diff.ObjectReflectDiff(expected, got))
+	}
+}
+
+func TestGraphOpportunitiesTagSpecificationKind(t *testing.T) {
+	changed := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			ReleaseTagConfiguration: &api.ReleaseTagConfiguration{Namespace: "ci", Name: "release"},
+		},
+	}
+	all := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": changed["base-org-repo-branch.yaml"],
+		"consumer-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			ReleaseTagConfiguration: &api.ReleaseTagConfiguration{Namespace: "ci", Name: "release"},
+		},
+	}
+
+	opportunities := graphOpportunities(changed, all, config.CiTemplates{}, aliasGraph{})
+
+	got, ok := opportunities["consumer-org-repo-branch.yaml"]
+	if !ok {
+		t.Fatalf("expected an opportunity for the config sharing the changed tag_specification")
+	}
+	if got.Kind != "tag_specification" {
+		t.Errorf("expected a tag_specification opportunity, got kind %q", got.Kind)
+	}
+}
+
+func TestGraphOpportunitiesTemplates(t *testing.T) {
+	changedTemplates := config.CiTemplates{"awesome-openshift-installer.yaml": nil}
+	changed := config.CompoundCiopConfig{}
+	all := config.CompoundCiopConfig{
+		"consumer-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			Tests: []api.TestStepConfiguration{{As: "e2e", Template: "awesome-openshift-installer"}},
+		},
+		"unrelated-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			Tests: []api.TestStepConfiguration{{As: "e2e", Template: "old-ugly-ansible-installer"}},
+		},
+	}
+
+	opportunities := graphOpportunities(changed, all, changedTemplates, aliasGraph{})
+
+	if _, ok := opportunities["unrelated-org-repo-branch.yaml"]; ok {
+		t.Errorf("expected no opportunity for a config using an unchanged template")
+	}
+	got, ok := opportunities["consumer-org-repo-branch.yaml"]
+	if !ok {
+		t.Fatalf("expected an opportunity for the config using the changed template")
+	}
+	expected := OpportunityReason{
+		Kind:   "template",
+		Source: "awesome-openshift-installer",
+		Path:   []string{"awesome-openshift-installer", "consumer-org-repo-branch.yaml"},
+	}
+	if !reflect.DeepEqual(expected, got) {
+		t.Errorf("opportunity differs from expected:\n%s", diff.ObjectReflectDiff(expected, got))
+	}
+}
+
+func TestBuildAliasGraph(t *testing.T) {
+	steps := []api.OutputImageTagStepConfiguration{
+		{
+			From:  api.PipelineImageStreamTagReference("src"),
+			To:    api.ImageStreamTagReference{Namespace: "ci", Name: "alias", Tag: "latest"},
+			Alias: true,
+		},
+		{
+			From: api.PipelineImageStreamTagReference("other"),
+			To:   api.ImageStreamTagReference{Namespace: "ci", Name: "not-alias", Tag: "latest"},
+		},
+	}
+
+	graph := buildAliasGraph(steps)
+
+	alias := tagRef{Namespace: "ci", Name: "alias", Tag: "latest"}
+	resolved, ok := graph[alias]
+	if !ok {
+		t.Fatalf("expected the aliased tag to be present in the graph")
+	}
+	expected := tagRef{Namespace: "ci", Name: api.PipelineImageStream, Tag: "src"}
+	if resolved != expected {
+		t.Errorf("resolved alias source %v differs from expected %v", resolved, expected)
+	}
+	if _, ok := graph[tagRef{Namespace: "ci", Name: "not-alias", Tag: "latest"}]; ok {
+		t.Errorf("expected a non-alias output step to not appear in the alias graph")
+	}
+}
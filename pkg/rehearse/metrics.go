@@ -0,0 +1,131 @@
+package rehearse
+
+import (
+	"github.com/openshift/ci-operator/pkg/api"
+	prowconfig "k8s.io/test-infra/prow/config"
+
+	"github.com/openshift/ci-operator-prowgen/pkg/config"
+)
+
+// Metrics holds data about a single run of the rehearsal tooling. It
+// keeps the accumulated state in memory so that callers (and tests) can
+// inspect it directly, and also fans every recorded event out to the
+// Sinks registered with it, so the same run can be persisted to a file,
+// pushed to Prometheus, uploaded to GCS, or any combination thereof.
+type Metrics struct {
+	ChangedCiopConfigs []string                       `json:"changed_ciop_configs"`
+	ChangedTemplates   []string                       `json:"changed_templates"`
+	ChangedPresubmits  []string                       `json:"changed_presubmits"`
+	Opportunities      map[string][]OpportunityReason `json:"opportunities"`
+	Actual             []string                       `json:"actual"`
+
+	sinks []Sink
+}
+
+// NewMetrics creates an empty Metrics, wired up with a FileSink writing to
+// filename. Additional sinks can be registered with RegisterSink.
+func NewMetrics(filename string) *Metrics {
+	return &Metrics{
+		ChangedCiopConfigs: []string{},
+		ChangedTemplates:   []string{},
+		ChangedPresubmits:  []string{},
+		Opportunities:      map[string][]OpportunityReason{},
+		Actual:             []string{},
+		sinks:              []Sink{NewFileSink(filename)},
+	}
+}
+
+// RegisterSink adds an additional backend that recorded metrics are fanned
+// out to.
+func (m *Metrics) RegisterSink(sink Sink) {
+	m.sinks = append(m.sinks, sink)
+}
+
+func (m *Metrics) RecordChangedCiopConfigs(ciopConfigs config.CompoundCiopConfig) {
+	var added []string
+	for ciopConfig := range ciopConfigs {
+		added = append(added, ciopConfig)
+	}
+	m.ChangedCiopConfigs = append(m.ChangedCiopConfigs, added...)
+	for _, sink := range m.sinks {
+		sink.RecordChangedCiopConfigs(added)
+	}
+}
+
+func (m *Metrics) RecordChangedTemplates(templates config.CiTemplates) {
+	var added []string
+	for template := range templates {
+		added = append(added, template)
+	}
+	m.ChangedTemplates = append(m.ChangedTemplates, added...)
+	for _, sink := range m.sinks {
+		sink.RecordChangedTemplates(added)
+	}
+}
+
+func (m *Metrics) RecordChangedPresubmits(presubmits config.Presubmits) {
+	var added []string
+	for _, repoPresubmits := range presubmits {
+		for _, presubmit := range repoPresubmits {
+			added = append(added, presubmit.Name)
+		}
+	}
+	m.ChangedPresubmits = append(m.ChangedPresubmits, added...)
+	for _, sink := range m.sinks {
+		sink.RecordChangedPresubmits(added)
+	}
+}
+
+func (m *Metrics) RecordOpportunity(presubmits config.Presubmits, reason OpportunityReason) {
+	for _, repoPresubmits := range presubmits {
+		for _, presubmit := range repoPresubmits {
+			m.Opportunities[presubmit.Name] = append(m.Opportunities[presubmit.Name], reason)
+			for _, sink := range m.sinks {
+				sink.RecordOpportunity(presubmit.Name, reason)
+			}
+		}
+	}
+}
+
+func (m *Metrics) RecordActual(presubmits []*prowconfig.Presubmit) {
+	var added []string
+	for _, presubmit := range presubmits {
+		added = append(added, presubmit.Name)
+	}
+	m.Actual = append(m.Actual, added...)
+	for _, sink := range m.sinks {
+		sink.RecordActual(added)
+	}
+}
+
+// RecordGraphOpportunities builds the alias/tag-reference dependency
+// graph between changed ci-operator configs, changed templates and the
+// full set of known ci-operator configs, and records an opportunity -
+// annotated with the path through that graph that justified it - for
+// every presubmit whose ci-operator config is connected to a change.
+// promotionSteps should cover every output image tag step configured
+// across all known configs, so that Alias-mode tags are resolved
+// correctly regardless of which config they were defined in.
+func (m *Metrics) RecordGraphOpportunities(changed, all config.CompoundCiopConfig, changedTemplates config.CiTemplates, promotionSteps []api.OutputImageTagStepConfiguration, presubmitsForConfig map[string]config.Presubmits) {
+	aliases := buildAliasGraph(promotionSteps)
+	for name, reason := range graphOpportunities(changed, all, changedTemplates, aliases) {
+		presubmits, ok := presubmitsForConfig[name]
+		if !ok {
+			continue
+		}
+		m.RecordOpportunity(presubmits, reason)
+	}
+}
+
+// Flush persists the accumulated metrics to every registered sink and
+// returns the first error encountered, if any, after attempting all of
+// them.
+func (m *Metrics) Flush() error {
+	var firstErr error
+	for _, sink := range m.sinks {
+		if err := sink.Flush(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -125,44 +125,47 @@ func TestRecordOpportunity(t *testing.T) {
 
 	var testCases = []struct {
 		description string
-		existing    map[string][]string
+		existing    map[string][]OpportunityReason
 		presubmits  map[string][]string
-		reason      string
-		expected    map[string][]string
+		reason      OpportunityReason
+		expected    map[string][]OpportunityReason
 	}{{
 		description: "no opportunities",
-		existing:    map[string][]string{},
-		reason:      "no reason",
-		expected:    map[string][]string{},
+		existing:    map[string][]OpportunityReason{},
+		reason:      OpportunityReason{Kind: "template", Source: "no-source.yaml"},
+		expected:    map[string][]OpportunityReason{},
 	}, {
 		description: "opportunity in a single repo",
-		existing:    map[string][]string{},
+		existing:    map[string][]OpportunityReason{},
 		presubmits:  map[string][]string{"org/repo": {"org-repo-job", "org-repo-another-job"}},
-		reason:      "something changed",
-		expected: map[string][]string{
-			"org-repo-another-job": {"something changed"},
-			"org-repo-job":         {"something changed"},
+		reason:      OpportunityReason{Kind: "template", Source: "awesome-openshift-installer.yaml", Path: []string{"awesome-openshift-installer.yaml"}},
+		expected: map[string][]OpportunityReason{
+			"org-repo-another-job": {{Kind: "template", Source: "awesome-openshift-installer.yaml", Path: []string{"awesome-openshift-installer.yaml"}}},
+			"org-repo-job":         {{Kind: "template", Source: "awesome-openshift-installer.yaml", Path: []string{"awesome-openshift-installer.yaml"}}},
 		},
 	}, {
 		description: "opportunities in multiple repos",
-		existing:    map[string][]string{},
+		existing:    map[string][]OpportunityReason{},
 		presubmits: map[string][]string{
 			"org/repo":         {"org-repo-job", "org-repo-another-job"},
 			"org/another-repo": {"org-another-repo-job"},
 		},
-		reason: "something changed",
-		expected: map[string][]string{
-			"org-another-repo-job": {"something changed"},
-			"org-repo-another-job": {"something changed"},
-			"org-repo-job":         {"something changed"},
+		reason: OpportunityReason{Kind: "ciop_config", Source: "org-repo-branch.yaml"},
+		expected: map[string][]OpportunityReason{
+			"org-another-repo-job": {{Kind: "ciop_config", Source: "org-repo-branch.yaml"}},
+			"org-repo-another-job": {{Kind: "ciop_config", Source: "org-repo-branch.yaml"}},
+			"org-repo-job":         {{Kind: "ciop_config", Source: "org-repo-branch.yaml"}},
 		},
 	}, {
 		description: "opportunities for multiple reasons",
-		existing:    map[string][]string{"org-repo-job": {"something changed"}},
+		existing:    map[string][]OpportunityReason{"org-repo-job": {{Kind: "ciop_config", Source: "org-repo-branch.yaml"}}},
 		presubmits:  map[string][]string{"org/repo": {"org-repo-job"}},
-		reason:      "something else changed",
-		expected: map[string][]string{
-			"org-repo-job": {"something changed", "something else changed"},
+		reason:      OpportunityReason{Kind: "base_image", Source: "base.yaml", Path: []string{"base.yaml", "org-repo-branch.yaml", "org-repo-job"}},
+		expected: map[string][]OpportunityReason{
+			"org-repo-job": {
+				{Kind: "ciop_config", Source: "org-repo-branch.yaml"},
+				{Kind: "base_image", Source: "base.yaml", Path: []string{"base.yaml", "org-repo-branch.yaml", "org-repo-job"}},
+			},
 		},
 	}}
 	for _, tc := range testCases {
@@ -185,6 +188,62 @@ func TestRecordOpportunity(t *testing.T) {
 	}
 }
 
+func TestRecordGraphOpportunities(t *testing.T) {
+	testFilename := ""
+
+	changed := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"base": {Namespace: "ci", Name: api.PipelineImageStream, Tag: "src"},
+			},
+		},
+	}
+	all := config.CompoundCiopConfig{
+		"base-org-repo-branch.yaml": changed["base-org-repo-branch.yaml"],
+		"consumer-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"alias": {Namespace: "ci", Name: "alias", Tag: "latest"},
+			},
+		},
+		"unwired-org-repo-branch.yaml": &api.ReleaseBuildConfiguration{
+			BaseImages: map[string]api.ImageStreamTagReference{
+				"also-alias": {Namespace: "ci", Name: "alias", Tag: "latest"},
+			},
+		},
+	}
+	promotionSteps := []api.OutputImageTagStepConfiguration{{
+		From:  api.PipelineImageStreamTagReference("src"),
+		To:    api.ImageStreamTagReference{Namespace: "ci", Name: "alias", Tag: "latest"},
+		Alias: true,
+	}}
+	presubmitsForConfig := map[string]config.Presubmits{
+		"consumer-org-repo-branch.yaml": {
+			"org/repo": {{JobBase: prowconfig.JobBase{Name: "org-repo-job"}}},
+		},
+		// unwired-org-repo-branch.yaml is connected to the change through
+		// the alias graph but has no presubmits registered against it, so
+		// it must be skipped rather than looked up and recorded.
+	}
+
+	metrics := NewMetrics(testFilename)
+	metrics.RecordGraphOpportunities(changed, all, config.CiTemplates{}, promotionSteps, presubmitsForConfig)
+
+	expected := map[string][]OpportunityReason{
+		"org-repo-job": {{
+			Kind:   "base_image",
+			Source: "base-org-repo-branch.yaml",
+			Path: []string{
+				"ci/alias:latest",
+				"ci/" + api.PipelineImageStream + ":src",
+				"consumer-org-repo-branch.yaml",
+			},
+		}},
+	}
+	if !reflect.DeepEqual(expected, metrics.Opportunities) {
+		t.Errorf("Recorded rehearsal opportunities differ from expected:\n%s", diff.ObjectReflectDiff(expected, metrics.Opportunities))
+	}
+}
+
 func TestRecordActual(t *testing.T) {
 	testFilename := ""
 	testCases := []struct {
@@ -0,0 +1,55 @@
+package rehearse
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// MetricsOptions exposes the set of Sinks a rehearsal run flushes its
+// Metrics to as command-line flags, so operators can opt into the
+// Prometheus and GCS backends without recompiling.
+type MetricsOptions struct {
+	MetricsFile    string
+	Sinks          string
+	PushgatewayURL string
+	PushgatewayJob string
+	GCSBucket      string
+	GCSObject      string
+}
+
+// Bind registers the metrics sink flags on fs.
+func (o *MetricsOptions) Bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.MetricsFile, "metrics-file", "", "If set, write rehearsal metrics as a JSON file here.")
+	fs.StringVar(&o.Sinks, "metrics-sinks", "file", "Comma-separated list of metrics sinks to enable: file, prometheus, gcs. The file sink is always enabled and writes only when -metrics-file is set.")
+	fs.StringVar(&o.PushgatewayURL, "metrics-pushgateway-url", "", "Prometheus pushgateway URL used by the prometheus metrics sink.")
+	fs.StringVar(&o.PushgatewayJob, "metrics-pushgateway-job", "pj-rehearse", "Prometheus pushgateway job name used by the prometheus metrics sink.")
+	fs.StringVar(&o.GCSBucket, "metrics-gcs-bucket", "", "GCS bucket the gcs metrics sink uploads a rehearsal metrics artifact to.")
+	fs.StringVar(&o.GCSObject, "metrics-gcs-object", "", "GCS object name the gcs metrics sink uploads a rehearsal metrics artifact to.")
+}
+
+// Metrics builds a Metrics instance backed by a FileSink writing to
+// MetricsFile, with every additional sink named in Sinks registered
+// alongside it.
+func (o *MetricsOptions) Metrics() (*Metrics, error) {
+	metrics := NewMetrics(o.MetricsFile)
+	for _, name := range strings.Split(o.Sinks, ",") {
+		switch strings.TrimSpace(name) {
+		case "", "file":
+			// FileSink is always registered by NewMetrics.
+		case "prometheus":
+			if len(o.PushgatewayURL) == 0 {
+				return nil, fmt.Errorf("-metrics-pushgateway-url must be set to enable the prometheus metrics sink")
+			}
+			metrics.RegisterSink(NewPrometheusPushSink(o.PushgatewayURL, o.PushgatewayJob))
+		case "gcs":
+			if len(o.GCSBucket) == 0 || len(o.GCSObject) == 0 {
+				return nil, fmt.Errorf("-metrics-gcs-bucket and -metrics-gcs-object must be set to enable the gcs metrics sink")
+			}
+			metrics.RegisterSink(NewGCSSink(o.GCSBucket, o.GCSObject))
+		default:
+			return nil, fmt.Errorf("unknown metrics sink %q", name)
+		}
+	}
+	return metrics, nil
+}
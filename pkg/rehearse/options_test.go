@@ -0,0 +1,47 @@
+package rehearse
+
+import "testing"
+
+func TestMetricsOptionsMetrics(t *testing.T) {
+	testCases := []struct {
+		description string
+		options     MetricsOptions
+		expectErr   bool
+	}{{
+		description: "file sink only",
+		options:     MetricsOptions{Sinks: "file"},
+	}, {
+		description: "empty sink list defaults to file",
+		options:     MetricsOptions{},
+	}, {
+		description: "prometheus sink without a pushgateway URL errors",
+		options:     MetricsOptions{Sinks: "prometheus"},
+		expectErr:   true,
+	}, {
+		description: "prometheus sink with a pushgateway URL",
+		options:     MetricsOptions{Sinks: "file,prometheus", PushgatewayURL: "http://pushgateway", PushgatewayJob: "rehearse"},
+	}, {
+		description: "gcs sink without a bucket and object errors",
+		options:     MetricsOptions{Sinks: "gcs"},
+		expectErr:   true,
+	}, {
+		description: "gcs sink with a bucket and object",
+		options:     MetricsOptions{Sinks: "gcs", GCSBucket: "bucket", GCSObject: "object.json"},
+	}, {
+		description: "unknown sink errors",
+		options:     MetricsOptions{Sinks: "carrier-pigeon"},
+		expectErr:   true,
+	}}
+
+	for _, tc := range testCases {
+		t.Run(tc.description, func(t *testing.T) {
+			_, err := tc.options.Metrics()
+			if tc.expectErr && err == nil {
+				t.Errorf("expected an error, got none")
+			}
+			if !tc.expectErr && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,227 @@
+package rehearse
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/push"
+
+	"cloud.google.com/go/storage"
+	"context"
+)
+
+// Sink is a backend that rehearsal metrics are recorded to as they
+// happen. Every RecordX method is called with only the batch newly
+// observed in that call, not the cumulative total - implementations that
+// need the running total (e.g. FileSink) are expected to accumulate it
+// themselves. Implementations are expected to be cheap to call from
+// RecordX methods; anything that needs to talk to the network should
+// batch the work and perform it in Flush instead.
+type Sink interface {
+	RecordChangedCiopConfigs(ciopConfigs []string)
+	RecordChangedTemplates(templates []string)
+	RecordChangedPresubmits(presubmits []string)
+	RecordOpportunity(job string, reason OpportunityReason)
+	RecordActual(jobs []string)
+
+	// Flush persists whatever the sink has accumulated so far to its
+	// backend.
+	Flush() error
+}
+
+// FileSink is the original rehearsal metrics behavior: it dumps the full
+// set of recorded fields as a single JSON document to a file when Flush is
+// called.
+type FileSink struct {
+	filename string
+
+	changedCiopConfigs []string
+	changedTemplates   []string
+	changedPresubmits  []string
+	opportunities      map[string][]OpportunityReason
+	actual             []string
+}
+
+// NewFileSink creates a Sink that writes a JSON dump of the recorded
+// metrics to filename on Flush. An empty filename disables writing.
+func NewFileSink(filename string) *FileSink {
+	return &FileSink{filename: filename, opportunities: map[string][]OpportunityReason{}}
+}
+
+func (s *FileSink) RecordChangedCiopConfigs(ciopConfigs []string) {
+	s.changedCiopConfigs = append(s.changedCiopConfigs, ciopConfigs...)
+}
+func (s *FileSink) RecordChangedTemplates(templates []string) {
+	s.changedTemplates = append(s.changedTemplates, templates...)
+}
+func (s *FileSink) RecordChangedPresubmits(presubmits []string) {
+	s.changedPresubmits = append(s.changedPresubmits, presubmits...)
+}
+func (s *FileSink) RecordActual(jobs []string) { s.actual = append(s.actual, jobs...) }
+
+func (s *FileSink) RecordOpportunity(job string, reason OpportunityReason) {
+	s.opportunities[job] = append(s.opportunities[job], reason)
+}
+
+func (s *FileSink) marshal() ([]byte, error) {
+	raw, err := json.MarshalIndent(struct {
+		ChangedCiopConfigs []string                       `json:"changed_ciop_configs"`
+		ChangedTemplates   []string                       `json:"changed_templates"`
+		ChangedPresubmits  []string                       `json:"changed_presubmits"`
+		Opportunities      map[string][]OpportunityReason `json:"opportunities"`
+		Actual             []string                       `json:"actual"`
+	}{
+		ChangedCiopConfigs: s.changedCiopConfigs,
+		ChangedTemplates:   s.changedTemplates,
+		ChangedPresubmits:  s.changedPresubmits,
+		Opportunities:      s.opportunities,
+		Actual:             s.actual,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal rehearsal metrics: %v", err)
+	}
+	return raw, nil
+}
+
+func (s *FileSink) Flush() error {
+	if len(s.filename) == 0 {
+		return nil
+	}
+	raw, err := s.marshal()
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(s.filename, raw, 0644); err != nil {
+		return fmt.Errorf("could not write rehearsal metrics to %s: %v", s.filename, err)
+	}
+	return nil
+}
+
+// PrometheusPushSink pushes rehearsal counters to a Prometheus pushgateway
+// so that rehearsal activity can be tracked on dashboards and alerted on,
+// instead of only being visible in a per-PR artifact.
+type PrometheusPushSink struct {
+	pushgatewayURL string
+	job            string
+
+	opportunities     *prometheus.CounterVec
+	actual            prometheus.Counter
+	changedCiopConfig *prometheus.CounterVec
+}
+
+// NewPrometheusPushSink creates a Sink that pushes its counters to the
+// pushgateway at pushgatewayURL under the given job name when Flush is
+// called.
+func NewPrometheusPushSink(pushgatewayURL, job string) *PrometheusPushSink {
+	return &PrometheusPushSink{
+		pushgatewayURL: pushgatewayURL,
+		job:            job,
+		opportunities: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rehearsal_opportunities_total",
+			Help: "Number of rehearsal opportunities recorded, by reason.",
+		}, []string{"reason"}),
+		actual: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "rehearsal_actual_total",
+			Help: "Number of rehearsals actually triggered.",
+		}),
+		changedCiopConfig: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "rehearsal_changed_configs_total",
+			Help: "Number of changed configs detected, by kind.",
+		}, []string{"kind"}),
+	}
+}
+
+func (s *PrometheusPushSink) RecordChangedCiopConfigs(ciopConfigs []string) {
+	s.changedCiopConfig.WithLabelValues("ciop-config").Add(float64(len(ciopConfigs)))
+}
+
+func (s *PrometheusPushSink) RecordChangedTemplates(templates []string) {
+	s.changedCiopConfig.WithLabelValues("template").Add(float64(len(templates)))
+}
+
+func (s *PrometheusPushSink) RecordChangedPresubmits(presubmits []string) {
+	s.changedCiopConfig.WithLabelValues("presubmit").Add(float64(len(presubmits)))
+}
+
+func (s *PrometheusPushSink) RecordOpportunity(job string, reason OpportunityReason) {
+	s.opportunities.WithLabelValues(reason.Kind).Inc()
+}
+
+func (s *PrometheusPushSink) RecordActual(jobs []string) {
+	s.actual.Add(float64(len(jobs)))
+}
+
+func (s *PrometheusPushSink) Flush() error {
+	if len(s.pushgatewayURL) == 0 {
+		return nil
+	}
+	if err := push.New(s.pushgatewayURL, s.job).
+		Collector(s.opportunities).
+		Collector(s.actual).
+		Collector(s.changedCiopConfig).
+		Push(); err != nil {
+		return fmt.Errorf("could not push rehearsal metrics to %s: %v", s.pushgatewayURL, err)
+	}
+	return nil
+}
+
+// GCSSink writes a per-PR metrics artifact to a GCS bucket, mirroring the
+// layout ci-operator itself uses for other job artifacts.
+type GCSSink struct {
+	bucket string
+	object string
+
+	fileSink *FileSink
+}
+
+// NewGCSSink creates a Sink that uploads the recorded metrics as a JSON
+// object to gs://bucket/object when Flush is called.
+func NewGCSSink(bucket, object string) *GCSSink {
+	return &GCSSink{bucket: bucket, object: object, fileSink: NewFileSink("")}
+}
+
+func (s *GCSSink) RecordChangedCiopConfigs(ciopConfigs []string) {
+	s.fileSink.RecordChangedCiopConfigs(ciopConfigs)
+}
+
+func (s *GCSSink) RecordChangedTemplates(templates []string) {
+	s.fileSink.RecordChangedTemplates(templates)
+}
+
+func (s *GCSSink) RecordChangedPresubmits(presubmits []string) {
+	s.fileSink.RecordChangedPresubmits(presubmits)
+}
+
+func (s *GCSSink) RecordOpportunity(job string, reason OpportunityReason) {
+	s.fileSink.RecordOpportunity(job, reason)
+}
+
+func (s *GCSSink) RecordActual(jobs []string) {
+	s.fileSink.RecordActual(jobs)
+}
+
+func (s *GCSSink) Flush() error {
+	raw, err := s.fileSink.marshal()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx)
+	if err != nil {
+		return fmt.Errorf("could not create GCS client: %v", err)
+	}
+	defer client.Close()
+
+	writer := client.Bucket(s.bucket).Object(s.object).NewWriter(ctx)
+	if _, err := writer.Write(raw); err != nil {
+		return fmt.Errorf("could not write rehearsal metrics to gs://%s/%s: %v", s.bucket, s.object, err)
+	}
+	if err := writer.Close(); err != nil {
+		return fmt.Errorf("could not finalize rehearsal metrics upload to gs://%s/%s: %v", s.bucket, s.object, err)
+	}
+	log.Printf("Uploaded rehearsal metrics to gs://%s/%s", s.bucket, s.object)
+	return nil
+}
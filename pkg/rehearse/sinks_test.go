@@ -0,0 +1,118 @@
+package rehearse
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/util/diff"
+)
+
+func TestFileSinkFlush(t *testing.T) {
+	dir, err := ioutil.TempDir("", "rehearse-metrics")
+	if err != nil {
+		t.Fatalf("could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	filename := dir + "/metrics.json"
+
+	sink := NewFileSink(filename)
+	sink.RecordChangedCiopConfigs([]string{"org-repo-branch.yaml"})
+	sink.RecordChangedTemplates([]string{"installer.yaml"})
+	sink.RecordChangedPresubmits([]string{"org-repo-job"})
+	sink.RecordOpportunity("org-repo-job", OpportunityReason{Kind: "base_image", Source: "org-repo-branch.yaml", Path: []string{"org-repo-branch.yaml", "org-repo-job"}})
+	sink.RecordActual([]string{"rehearse-org-repo-job"})
+
+	if err := sink.Flush(); err != nil {
+		t.Fatalf("could not flush sink: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("could not read metrics file: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("could not unmarshal metrics file: %v", err)
+	}
+
+	expected := map[string]interface{}{
+		"changed_ciop_configs": []interface{}{"org-repo-branch.yaml"},
+		"changed_templates":    []interface{}{"installer.yaml"},
+		"changed_presubmits":   []interface{}{"org-repo-job"},
+		"opportunities": map[string]interface{}{"org-repo-job": []interface{}{
+			map[string]interface{}{
+				"kind":   "base_image",
+				"source": "org-repo-branch.yaml",
+				"path":   []interface{}{"org-repo-branch.yaml", "org-repo-job"},
+			},
+		}},
+		"actual": []interface{}{"rehearse-org-repo-job"},
+	}
+	if diff := diff.ObjectReflectDiff(expected, got); diff != "<no diffs>" {
+		t.Errorf("flushed metrics differ from expected:\n%s", diff)
+	}
+}
+
+func TestFileSinkFlushNoopWithoutFilename(t *testing.T) {
+	sink := NewFileSink("")
+	sink.RecordActual([]string{"rehearse-org-repo-job"})
+	if err := sink.Flush(); err != nil {
+		t.Errorf("expected flushing a sink with no filename to be a no-op, got: %v", err)
+	}
+}
+
+func TestPrometheusPushSinkCounters(t *testing.T) {
+	sink := NewPrometheusPushSink("", "rehearse")
+	sink.RecordChangedCiopConfigs([]string{"a", "b"})
+	sink.RecordChangedTemplates([]string{"c"})
+	sink.RecordChangedPresubmits([]string{"d"})
+	sink.RecordOpportunity("org-repo-job", OpportunityReason{Kind: "base_image", Source: "org-repo-branch.yaml"})
+	sink.RecordOpportunity("org-repo-job", OpportunityReason{Kind: "base_image", Source: "org-repo-branch.yaml"})
+	sink.RecordActual([]string{"rehearse-org-repo-job"})
+
+	if got := testutil.ToFloat64(sink.changedCiopConfig.WithLabelValues("ciop-config")); got != 2 {
+		t.Errorf("expected 2 changed ci-operator configs, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.changedCiopConfig.WithLabelValues("template")); got != 1 {
+		t.Errorf("expected 1 changed template, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.changedCiopConfig.WithLabelValues("presubmit")); got != 1 {
+		t.Errorf("expected 1 changed presubmit, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.opportunities.WithLabelValues("base_image")); got != 2 {
+		t.Errorf("expected 2 opportunities, got %v", got)
+	}
+	if got := testutil.ToFloat64(sink.actual); got != 1 {
+		t.Errorf("expected 1 actual rehearsal, got %v", got)
+	}
+
+	// An unset pushgateway URL means there is nowhere to push to, so Flush
+	// must not attempt to reach the network.
+	if err := sink.Flush(); err != nil {
+		t.Errorf("expected flushing a sink with no pushgateway URL to be a no-op, got: %v", err)
+	}
+}
+
+func TestGCSSinkMarshal(t *testing.T) {
+	sink := NewGCSSink("bucket", "path/to/object.json")
+	sink.RecordChangedCiopConfigs([]string{"org-repo-branch.yaml"})
+	sink.RecordActual([]string{"rehearse-org-repo-job"})
+
+	raw, err := sink.fileSink.marshal()
+	if err != nil {
+		t.Fatalf("could not marshal GCS sink payload: %v", err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(raw, &got); err != nil {
+		t.Fatalf("could not unmarshal GCS sink payload: %v", err)
+	}
+	if changed, ok := got["changed_ciop_configs"].([]interface{}); !ok || len(changed) != 1 || changed[0] != "org-repo-branch.yaml" {
+		t.Errorf("unexpected changed_ciop_configs in GCS sink payload: %v", got["changed_ciop_configs"])
+	}
+	if actual, ok := got["actual"].([]interface{}); !ok || len(actual) != 1 || actual[0] != "rehearse-org-repo-job" {
+		t.Errorf("unexpected actual in GCS sink payload: %v", got["actual"])
+	}
+}
@@ -5,26 +5,125 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"math/rand"
 	"strings"
+	"time"
 
 	imageapi "github.com/openshift/api/image/v1"
 	"github.com/openshift/ci-operator/pkg/api"
+	imagereference "github.com/openshift/library-go/pkg/image/reference"
+
 	imageclientset "github.com/openshift/client-go/image/clientset/versioned/typed/image/v1"
 	coreapi "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/util/retry"
+	coreclientset "k8s.io/client-go/kubernetes/typed/core/v1"
 )
 
+// RetryPolicy controls how outputImageTagStep retries an operation that
+// hit a conflict. It replaces the fixed retry.DefaultRetry backoff so that
+// callers running many of these steps concurrently (e.g. rehearsals) can
+// tune how long a single step is willing to block.
+type RetryPolicy struct {
+	// MaxAttempts bounds how many times the operation is attempted,
+	// including the first one.
+	MaxAttempts int
+	// InitialDelay is the base backoff between the first and second
+	// attempt; it doubles on every subsequent attempt.
+	InitialDelay time.Duration
+	// Jitter is the fraction of the computed delay that is added back on
+	// at random to avoid retry storms.
+	Jitter float64
+}
+
+// DefaultRetryPolicy mirrors the behavior of the retry.DefaultRetry
+// backoff this step used unconditionally before it became context-aware.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 10 * time.Millisecond,
+	Jitter:       1.0,
+}
+
+// withCancel runs fn in a goroutine and returns as soon as either fn
+// completes or ctx is done, whichever happens first. The istClient,
+// isClient and secretClient interfaces predate context-aware methods, so
+// a request already in flight when ctx is cancelled cannot actually be
+// aborted; the goroutine is left to finish in the background rather than
+// leaving the caller blocked on it.
+func withCancel(ctx context.Context, fn func() error) error {
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// retryOnConflict retries fn using policy until it succeeds, returns a
+// non-conflict error, runs out of attempts, or ctx is cancelled. Unlike
+// retry.RetryOnConflict it aborts promptly once ctx is done instead of
+// blocking for the full backoff window.
+func retryOnConflict(ctx context.Context, policy RetryPolicy, fn func() error) error {
+	delay := policy.InitialDelay
+	var err error
+	for attempt := 0; attempt < policy.MaxAttempts; attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		err = fn()
+		if err == nil || !errors.IsConflict(err) {
+			return err
+		}
+		if attempt == policy.MaxAttempts-1 {
+			break
+		}
+		wait := delay + time.Duration(policy.Jitter*rand.Float64()*float64(delay))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		delay *= 2
+	}
+	return err
+}
+
+// ValidateOutputImageTagStepConfiguration checks config for errors that
+// can be caught without contacting the cluster, such as a malformed
+// DockerImage pull spec. It needs no cluster access. Its only caller here
+// is Run, before it creates a DockerImage target, so a bad reference is
+// reported when a job reaches this step, not when the config is loaded.
+func ValidateOutputImageTagStepConfiguration(config api.OutputImageTagStepConfiguration) error {
+	if config.To.Kind == api.DockerImageKind {
+		if _, err := imagereference.Parse(config.To.DockerImageReference); err != nil {
+			return fmt.Errorf("output %s is not a valid DockerImage reference: %v", config.To.DockerImageReference, err)
+		}
+	}
+	return nil
+}
+
+// dockerImagePushSecretName is the name of the secret holding the
+// docker-registry credentials used to push to an external DockerImage
+// target. It is namespaced alongside the output ImageStreamTag.
+func dockerImagePushSecretName(as string) string {
+	return fmt.Sprintf("%s-push", as)
+}
+
 // outputImageTagStep will ensure that a tag exists
 // in the named ImageStream that resolves to the built
-// pipeline image
+// pipeline image. If the configuration requests an
+// alias, the tag instead tracks another ImageStreamTag
+// by reference and is never resolved to a digest.
 type outputImageTagStep struct {
-	config    api.OutputImageTagStepConfiguration
-	istClient imageclientset.ImageStreamTagsGetter
-	isClient  imageclientset.ImageStreamsGetter
-	jobSpec   *api.JobSpec
+	config       api.OutputImageTagStepConfiguration
+	istClient    imageclientset.ImageStreamTagsGetter
+	isClient     imageclientset.ImageStreamsGetter
+	secretClient coreclientset.SecretsGetter
+	jobSpec      *api.JobSpec
+	retryPolicy  RetryPolicy
 }
 
 func (s *outputImageTagStep) Inputs(ctx context.Context, dry bool) (api.InputDefinition, error) {
@@ -39,13 +138,29 @@ func (s *outputImageTagStep) Run(ctx context.Context, dry bool) error {
 		log.Printf("Tagging %s into %s/%s:%s", s.config.From, toNamespace, s.config.To.Name, s.config.To.Tag)
 	}
 	fromImage := "dry-fake"
-	if !dry {
-		from, err := s.istClient.ImageStreamTags(s.jobSpec.Namespace).Get(fmt.Sprintf("%s:%s", api.PipelineImageStream, s.config.From), meta.GetOptions{})
+	if !dry && !s.config.Alias && s.config.To.Kind != api.DockerImageKind {
+		var from *imageapi.ImageStreamTag
+		err := withCancel(ctx, func() error {
+			var err error
+			from, err = s.istClient.ImageStreamTags(s.jobSpec.Namespace).Get(fmt.Sprintf("%s:%s", api.PipelineImageStream, s.config.From), meta.GetOptions{})
+			return err
+		})
 		if err != nil {
 			return fmt.Errorf("could not resolve base image: %v", err)
 		}
 		fromImage = from.Image.Name
 	}
+	if s.config.To.Kind == api.DockerImageKind {
+		if err := ValidateOutputImageTagStepConfiguration(s.config); err != nil {
+			return err
+		}
+		if !dry {
+			if err := s.ensurePushSecret(ctx); err != nil {
+				return fmt.Errorf("could not ensure push secret: %v", err)
+			}
+		}
+	}
+
 	ist := s.imageStreamTag(fromImage)
 	if dry {
 		istJSON, err := json.MarshalIndent(ist, "", "  ")
@@ -57,36 +172,47 @@ func (s *outputImageTagStep) Run(ctx context.Context, dry bool) error {
 	}
 
 	// Create if not exists, update if it does
-	if _, err := s.istClient.ImageStreamTags(toNamespace).Create(ist); err != nil {
-		if errors.IsAlreadyExists(err) {
-			err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
-				existingIst, err := s.istClient.ImageStreamTags(ist.Namespace).Get(ist.Name, meta.GetOptions{})
-				if err != nil {
-					return err
-				}
-				// We don't care about the existing imagestreamtag's state, we just
-				// want it to look like the new one, so we only copy the
-				// ResourceVersion so we can update it.
-				ist.ResourceVersion = existingIst.ResourceVersion
-				if _, err = s.istClient.ImageStreamTags(toNamespace).Update(ist); err != nil {
-					return err
-				}
-				return nil
+	createErr := withCancel(ctx, func() error {
+		_, err := s.istClient.ImageStreamTags(toNamespace).Create(ist)
+		return err
+	})
+	if createErr != nil {
+		if errors.IsAlreadyExists(createErr) {
+			err := retryOnConflict(ctx, s.policy(), func() error {
+				return withCancel(ctx, func() error {
+					existingIst, err := s.istClient.ImageStreamTags(ist.Namespace).Get(ist.Name, meta.GetOptions{})
+					if err != nil {
+						return err
+					}
+					// We don't care about the existing imagestreamtag's state, we just
+					// want it to look like the new one, so we only copy the
+					// ResourceVersion so we can update it.
+					ist.ResourceVersion = existingIst.ResourceVersion
+					if _, err = s.istClient.ImageStreamTags(toNamespace).Update(ist); err != nil {
+						return err
+					}
+					return nil
+				})
 			})
 			if err != nil {
 				return fmt.Errorf("could not update output imagestreamtag: %v", err)
 			}
 		} else {
-			return fmt.Errorf("could not create output imagestreamtag: %v", err)
+			return fmt.Errorf("could not create output imagestreamtag: %v", createErr)
 		}
 	}
 	return nil
 }
 
-func (s *outputImageTagStep) Done() (bool, error) {
+func (s *outputImageTagStep) Done(ctx context.Context) (bool, error) {
 	toNamespace := s.namespace()
 	log.Printf("Checking for existence of %s/%s:%s", toNamespace, s.config.To.Name, s.config.To.Tag)
-	ist, err := s.istClient.ImageStreamTags(toNamespace).Get(fmt.Sprintf("%s:%s", s.config.To.Name, s.config.To.Tag), meta.GetOptions{})
+	var ist *imageapi.ImageStreamTag
+	err := withCancel(ctx, func() error {
+		var err error
+		ist, err = s.istClient.ImageStreamTags(toNamespace).Get(fmt.Sprintf("%s:%s", s.config.To.Name, s.config.To.Tag), meta.GetOptions{})
+		return err
+	})
 	if err != nil {
 		if errors.IsNotFound(err) {
 			return false, nil
@@ -94,9 +220,22 @@ func (s *outputImageTagStep) Done() (bool, error) {
 		return false, fmt.Errorf("could not retrieve output imagestreamtag: %v", err)
 	}
 
+	// Alias tags and DockerImage mirror targets don't resolve to a pipeline
+	// digest, so there is nothing to look up: the desired state is fully
+	// determined by the config.
+	if s.config.Alias || s.config.To.Kind == api.DockerImageKind {
+		desiredIst := s.imageStreamTag("")
+		return equality.Semantic.DeepEqual(ist.Tag, desiredIst.Tag), nil
+	}
+
 	// TODO(chance): this doesn't handle dry run since Done() doesn't have
 	// information about if it's a dry-run
-	from, err := s.istClient.ImageStreamTags(s.jobSpec.Namespace).Get(fmt.Sprintf("%s:%s", api.PipelineImageStream, s.config.From), meta.GetOptions{})
+	var from *imageapi.ImageStreamTag
+	err = withCancel(ctx, func() error {
+		var err error
+		from, err = s.istClient.ImageStreamTags(s.jobSpec.Namespace).Get(fmt.Sprintf("%s:%s", api.PipelineImageStream, s.config.From), meta.GetOptions{})
+		return err
+	})
 	if err != nil {
 		return false, fmt.Errorf("could not resolve base image: %v", err)
 	}
@@ -122,6 +261,13 @@ func (s *outputImageTagStep) Provides() (api.ParameterMap, api.StepLink) {
 	if len(s.config.To.As) == 0 {
 		return nil, nil
 	}
+	if s.config.To.Kind == api.DockerImageKind {
+		return api.ParameterMap{
+			fmt.Sprintf("IMAGE_%s", strings.ToUpper(strings.Replace(s.config.To.As, "-", "_", -1))): func() (string, error) {
+				return s.config.To.DockerImageReference, nil
+			},
+		}, api.ExternalImageLink(s.config.To)
+	}
 	return api.ParameterMap{
 		fmt.Sprintf("IMAGE_%s", strings.ToUpper(strings.Replace(s.config.To.As, "-", "_", -1))): func() (string, error) {
 			is, err := s.isClient.ImageStreams(s.namespace()).Get(s.config.To.Name, meta.GetOptions{})
@@ -155,6 +301,15 @@ func (s *outputImageTagStep) Description() string {
 	return fmt.Sprintf("Tag the image %s into the stable image stream", s.config.From)
 }
 
+// policy returns the configured retry policy, falling back to
+// DefaultRetryPolicy for steps constructed before RetryPolicy existed.
+func (s *outputImageTagStep) policy() RetryPolicy {
+	if s.retryPolicy.MaxAttempts == 0 {
+		return DefaultRetryPolicy
+	}
+	return s.retryPolicy
+}
+
 func (s *outputImageTagStep) namespace() string {
 	if len(s.config.To.Namespace) != 0 {
 		return s.config.To.Namespace
@@ -162,7 +317,92 @@ func (s *outputImageTagStep) namespace() string {
 	return s.jobSpec.Namespace
 }
 
+// ensurePushSecret makes sure the docker-registry pull/push secret the
+// external DockerImage target is pushed with is present and up to date in
+// the output namespace, creating it if it doesn't exist yet.
+func (s *outputImageTagStep) ensurePushSecret(ctx context.Context) error {
+	secret := &coreapi.Secret{
+		ObjectMeta: meta.ObjectMeta{
+			Name:      dockerImagePushSecretName(s.config.To.As),
+			Namespace: s.namespace(),
+		},
+		Type: coreapi.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			coreapi.DockerConfigJsonKey: s.config.To.PushSecret,
+		},
+	}
+	createErr := withCancel(ctx, func() error {
+		_, err := s.secretClient.Secrets(secret.Namespace).Create(secret)
+		return err
+	})
+	if createErr != nil {
+		if !errors.IsAlreadyExists(createErr) {
+			return fmt.Errorf("could not create push secret: %v", createErr)
+		}
+		err := retryOnConflict(ctx, s.policy(), func() error {
+			return withCancel(ctx, func() error {
+				existing, err := s.secretClient.Secrets(secret.Namespace).Get(secret.Name, meta.GetOptions{})
+				if err != nil {
+					return err
+				}
+				// As with the output imagestreamtag, we only need the
+				// existing ResourceVersion to make Update acceptable to the
+				// API server; the rest of the secret is fully replaced.
+				secret.ResourceVersion = existing.ResourceVersion
+				_, err = s.secretClient.Secrets(secret.Namespace).Update(secret)
+				return err
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("could not refresh push secret: %v", err)
+		}
+	}
+	return nil
+}
+
 func (s *outputImageTagStep) imageStreamTag(fromImage string) *imageapi.ImageStreamTag {
+	if s.config.To.Kind == api.DockerImageKind {
+		// The mirror record only tells the cluster what the external pull
+		// spec is; the registry performs the actual push using the
+		// credentials in the push secret.
+		return &imageapi.ImageStreamTag{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      fmt.Sprintf("%s:%s", s.config.To.Name, s.config.To.Tag),
+				Namespace: s.namespace(),
+			},
+			Tag: &imageapi.TagReference{
+				ReferencePolicy: imageapi.TagReferencePolicy{
+					Type: imageapi.LocalTagReferencePolicy,
+				},
+				From: &coreapi.ObjectReference{
+					Kind: api.DockerImageKind,
+					Name: s.config.To.DockerImageReference,
+				},
+			},
+		}
+	}
+	if s.config.Alias {
+		// Mirror `oc tag --alias`: the tag is a pure symbolic reference to
+		// another ImageStreamTag and is never imported or resolved to a
+		// digest, so it follows whatever that tag is later updated to.
+		return &imageapi.ImageStreamTag{
+			ObjectMeta: meta.ObjectMeta{
+				Name:      fmt.Sprintf("%s:%s", s.config.To.Name, s.config.To.Tag),
+				Namespace: s.namespace(),
+			},
+			Tag: &imageapi.TagReference{
+				Reference: true,
+				ReferencePolicy: imageapi.TagReferencePolicy{
+					Type: imageapi.LocalTagReferencePolicy,
+				},
+				From: &coreapi.ObjectReference{
+					Kind:      "ImageStreamTag",
+					Name:      fmt.Sprintf("%s:%s", api.PipelineImageStream, s.config.From),
+					Namespace: s.jobSpec.Namespace,
+				},
+			},
+		}
+	}
 	return &imageapi.ImageStreamTag{
 		ObjectMeta: meta.ObjectMeta{
 			Name:      fmt.Sprintf("%s:%s", s.config.To.Name, s.config.To.Tag),
@@ -181,11 +421,18 @@ func (s *outputImageTagStep) imageStreamTag(fromImage string) *imageapi.ImageStr
 	}
 }
 
-func OutputImageTagStep(config api.OutputImageTagStepConfiguration, istClient imageclientset.ImageStreamTagsGetter, isClient imageclientset.ImageStreamsGetter, jobSpec *api.JobSpec) api.Step {
+// OutputImageTagStep creates a step that ensures the configured output
+// ImageStreamTag exists and tracks the built image (or, in Alias mode,
+// another ImageStreamTag by reference). retryPolicy controls how long the
+// step retries an update that hits a conflict; the zero value falls back
+// to DefaultRetryPolicy.
+func OutputImageTagStep(config api.OutputImageTagStepConfiguration, istClient imageclientset.ImageStreamTagsGetter, isClient imageclientset.ImageStreamsGetter, secretClient coreclientset.SecretsGetter, jobSpec *api.JobSpec, retryPolicy RetryPolicy) api.Step {
 	return &outputImageTagStep{
-		config:    config,
-		istClient: istClient,
-		isClient:  isClient,
-		jobSpec:   jobSpec,
+		config:       config,
+		istClient:    istClient,
+		isClient:     isClient,
+		secretClient: secretClient,
+		jobSpec:      jobSpec,
+		retryPolicy:  retryPolicy,
 	}
 }
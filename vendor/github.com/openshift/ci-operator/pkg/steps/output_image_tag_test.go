@@ -0,0 +1,243 @@
+package steps
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/openshift/ci-operator/pkg/api"
+	imagefake "github.com/openshift/client-go/image/clientset/versioned/fake"
+	coreapi "k8s.io/api/core/v1"
+	kerrors "k8s.io/apimachinery/pkg/api/errors"
+	meta "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	corefake "k8s.io/client-go/kubernetes/fake"
+)
+
+func conflictErr() error {
+	return kerrors.NewConflict(schema.GroupResource{Resource: "imagestreamtags"}, "test", errors.New("conflict"))
+}
+
+func TestRetryOnConflictSucceedsWithoutRetry(t *testing.T) {
+	calls := 0
+	err := retryOnConflict(context.Background(), RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected exactly one attempt, got %d", calls)
+	}
+}
+
+func TestRetryOnConflictRetriesThenSucceeds(t *testing.T) {
+	calls := 0
+	err := retryOnConflict(context.Background(), RetryPolicy{MaxAttempts: 3, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		if calls < 3 {
+			return conflictErr()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("expected three attempts, got %d", calls)
+	}
+}
+
+func TestRetryOnConflictGivesUpAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	err := retryOnConflict(context.Background(), RetryPolicy{MaxAttempts: 2, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		return conflictErr()
+	})
+	if err == nil {
+		t.Fatal("expected an error after exhausting retries")
+	}
+	if calls != 2 {
+		t.Errorf("expected exactly MaxAttempts attempts, got %d", calls)
+	}
+}
+
+func TestRetryOnConflictStopsOnNonConflictError(t *testing.T) {
+	wantErr := errors.New("boom")
+	calls := 0
+	err := retryOnConflict(context.Background(), RetryPolicy{MaxAttempts: 5, InitialDelay: time.Millisecond}, func() error {
+		calls++
+		return wantErr
+	})
+	if err != wantErr {
+		t.Fatalf("expected the non-conflict error to be returned unchanged, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected a non-conflict error to stop retries immediately, got %d attempts", calls)
+	}
+}
+
+func TestRetryOnConflictAbortsOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := 0
+	err := retryOnConflict(ctx, RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second}, func() error {
+		calls++
+		return conflictErr()
+	})
+	if err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected a cancelled context to stop before any attempt, got %d", calls)
+	}
+}
+
+func TestRetryOnConflictAbortsDuringBackoff(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	calls := 0
+	err := retryOnConflict(ctx, RetryPolicy{MaxAttempts: 5, InitialDelay: time.Second}, func() error {
+		calls++
+		return conflictErr()
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("expected exactly one attempt before the backoff wait was interrupted, got %d", calls)
+	}
+}
+
+func TestWithCancelReturnsResultWhenFasterThanContext(t *testing.T) {
+	err := withCancel(context.Background(), func() error {
+		return errors.New("boom")
+	})
+	if err == nil || err.Error() != "boom" {
+		t.Errorf("expected the wrapped function's error to be returned, got %v", err)
+	}
+}
+
+func TestWithCancelAbortsOnCancelledContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	block := make(chan struct{})
+	defer close(block)
+	err := withCancel(ctx, func() error {
+		<-block
+		return nil
+	})
+	if err != context.Canceled {
+		t.Errorf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestRunAndDoneAliasMode(t *testing.T) {
+	images := imagefake.NewSimpleClientset()
+	secrets := corefake.NewSimpleClientset()
+	step := &outputImageTagStep{
+		config: api.OutputImageTagStepConfiguration{
+			From: api.PipelineImageStreamTagReference("src"),
+			To: api.ImageStreamTagReference{
+				Namespace: "target",
+				Name:      "stable",
+				Tag:       "latest",
+				As:        "exports",
+			},
+			Alias: true,
+		},
+		istClient:    images.ImageV1(),
+		isClient:     images.ImageV1(),
+		secretClient: secrets.CoreV1(),
+		jobSpec:      &api.JobSpec{Namespace: "job-namespace"},
+	}
+
+	if err := step.Run(context.Background(), false); err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	ist, err := images.ImageV1().ImageStreamTags("target").Get("stable:latest", meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not retrieve the created imagestreamtag: %v", err)
+	}
+	if ist.Tag == nil || !ist.Tag.Reference {
+		t.Fatalf("expected an alias (tracking) tag, got %#v", ist.Tag)
+	}
+	wantFrom := fmt.Sprintf("%s:%s", api.PipelineImageStream, "src")
+	if ist.Tag.From == nil || ist.Tag.From.Name != wantFrom || ist.Tag.From.Namespace != "job-namespace" {
+		t.Fatalf("expected the alias to track %s in job-namespace, got %#v", wantFrom, ist.Tag.From)
+	}
+
+	done, err := step.Done(context.Background())
+	if err != nil {
+		t.Fatalf("Done returned an unexpected error: %v", err)
+	}
+	if !done {
+		t.Errorf("expected Done to report true once the alias tag matches the desired spec")
+	}
+}
+
+func TestRunDockerImageMode(t *testing.T) {
+	images := imagefake.NewSimpleClientset()
+	secrets := corefake.NewSimpleClientset()
+	step := &outputImageTagStep{
+		config: api.OutputImageTagStepConfiguration{
+			From: api.PipelineImageStreamTagReference("src"),
+			To: api.ImageStreamTagReference{
+				Namespace:            "target",
+				Name:                 "mirror",
+				Tag:                  "latest",
+				As:                   "exports",
+				Kind:                 api.DockerImageKind,
+				DockerImageReference: "registry.example.com/org/repo:latest",
+				PushSecret:           []byte(`{"auths":{}}`),
+			},
+		},
+		istClient:    images.ImageV1(),
+		isClient:     images.ImageV1(),
+		secretClient: secrets.CoreV1(),
+		jobSpec:      &api.JobSpec{Namespace: "job-namespace"},
+	}
+
+	if err := step.Run(context.Background(), false); err != nil {
+		t.Fatalf("Run returned an unexpected error: %v", err)
+	}
+
+	ist, err := images.ImageV1().ImageStreamTags("target").Get("mirror:latest", meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("could not retrieve the created imagestreamtag: %v", err)
+	}
+	if ist.Tag == nil || ist.Tag.From == nil || ist.Tag.From.Kind != api.DockerImageKind || ist.Tag.From.Name != "registry.example.com/org/repo:latest" {
+		t.Fatalf("expected a DockerImage mirror tag, got %#v", ist.Tag)
+	}
+
+	secret, err := secrets.CoreV1().Secrets("target").Get(dockerImagePushSecretName("exports"), meta.GetOptions{})
+	if err != nil {
+		t.Fatalf("expected the push secret to have been created: %v", err)
+	}
+	if secret.Type != coreapi.SecretTypeDockerConfigJson {
+		t.Errorf("expected a dockerconfigjson push secret, got type %q", secret.Type)
+	}
+
+	params, _ := step.Provides()
+	getImage, ok := params["IMAGE_EXPORTS"]
+	if !ok {
+		t.Fatalf("expected a parameter for the exported image")
+	}
+	value, err := getImage()
+	if err != nil {
+		t.Fatalf("could not resolve the exported image parameter: %v", err)
+	}
+	if value != "registry.example.com/org/repo:latest" {
+		t.Errorf("expected the exported image parameter to be the DockerImage reference, got %q", value)
+	}
+
+	// Running again must hit the AlreadyExists path for both the push
+	// secret and the imagestreamtag without erroring.
+	if err := step.Run(context.Background(), false); err != nil {
+		t.Fatalf("second Run returned an unexpected error: %v", err)
+	}
+}